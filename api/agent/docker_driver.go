@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// dockerFreezer is the default ContainerFreezer: it pauses/unpauses through
+// the Docker Engine API client, the same kind of client the runner's
+// docker driver dials, rather than shelling out to the docker CLI binary.
+// containerID always comes from SetContainerID (the driver-assigned ID),
+// never from tenant-supplied config.
+type dockerFreezer struct {
+	once   sync.Once
+	client *client.Client
+	err    error
+}
+
+// NewDockerFreezer returns a ContainerFreezer backed by a Docker Engine API
+// client dialed from the environment (DOCKER_HOST et al., same as the
+// runner's own driver).
+func NewDockerFreezer() ContainerFreezer { return &dockerFreezer{} }
+
+func (d *dockerFreezer) ensureClient() (*client.Client, error) {
+	d.once.Do(func() {
+		d.client, d.err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	})
+	return d.client, d.err
+}
+
+func (d *dockerFreezer) Pause(ctx context.Context, containerID string) error {
+	cli, err := d.ensureClient()
+	if err != nil {
+		return fmt.Errorf("agent: could not create docker client: %v", err)
+	}
+	if err := cli.ContainerPause(ctx, containerID); err != nil {
+		return fmt.Errorf("agent: could not pause container %s: %v", containerID, err)
+	}
+	return nil
+}
+
+func (d *dockerFreezer) Unpause(ctx context.Context, containerID string) error {
+	cli, err := d.ensureClient()
+	if err != nil {
+		return fmt.Errorf("agent: could not create docker client: %v", err)
+	}
+	if err := cli.ContainerUnpause(ctx, containerID); err != nil {
+		return fmt.Errorf("agent: could not unpause container %s: %v", containerID, err)
+	}
+	return nil
+}
+
+// dockerExecutor is the default ContainerExecutor: it runs the probe
+// command inside the container via the Docker Engine API's exec endpoints
+// (the same primitives `docker exec` itself is built on), sandboxed inside
+// the function's own container rather than on the agent host.
+type dockerExecutor struct {
+	once   sync.Once
+	client *client.Client
+	err    error
+}
+
+// NewDockerExecutor returns a ContainerExecutor backed by a Docker Engine
+// API client dialed from the environment, the same way NewDockerFreezer
+// does.
+func NewDockerExecutor() ContainerExecutor { return &dockerExecutor{} }
+
+func (d *dockerExecutor) ensureClient() (*client.Client, error) {
+	d.once.Do(func() {
+		d.client, d.err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	})
+	return d.client, d.err
+}
+
+func (d *dockerExecutor) Exec(ctx context.Context, containerID string, cmd string) (string, int, error) {
+	cli, err := d.ensureClient()
+	if err != nil {
+		return "", -1, fmt.Errorf("agent: could not create docker client: %v", err)
+	}
+
+	created, err := cli.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          []string{"/bin/sh", "-c", cmd},
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", -1, fmt.Errorf("agent: could not create exec in container %s: %v", containerID, err)
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return "", -1, fmt.Errorf("agent: could not attach exec in container %s: %v", containerID, err)
+	}
+	defer attach.Close()
+
+	// ContainerExecCreate runs without a TTY, so stdout/stderr arrive
+	// multiplexed with stdcopy's 8-byte stream-header framing; demux both
+	// into the same buffer rather than io.Copy-ing the raw frames into
+	// ProbeResult.Output.
+	var out bytes.Buffer
+	if _, err := stdcopy.StdCopy(&out, &out, attach.Reader); err != nil {
+		return "", -1, fmt.Errorf("agent: could not read exec output in container %s: %v", containerID, err)
+	}
+
+	inspect, err := cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return out.String(), -1, fmt.Errorf("agent: could not inspect exec in container %s: %v", containerID, err)
+	}
+
+	return out.String(), inspect.ExitCode, nil
+}
@@ -1,6 +1,11 @@
 package agent
 
-import "github.com/fnproject/fn/api/models"
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fnproject/fn/api/models"
+)
 
 // FuncError is an error that is the function's fault, that uses the
 // models.APIError but distinguishes fault to function specific errors
@@ -22,3 +27,24 @@ func NewFuncError(err models.APIError) error { return concFuncError{err} }
 
 // IsFuncError checks if err is of type FuncError
 func IsFuncError(err error) bool { _, ok := err.(FuncError); return ok }
+
+// oomFuncError is a FuncError raised when the container backing the call
+// was killed by the OOM killer rather than exiting (or erroring) on its
+// own. It's kept distinct from concFuncError so callers can tell "your
+// function ran out of memory" apart from "your code returned a 5xx".
+type oomFuncError struct {
+	concFuncError
+}
+
+// ErrCallOOMKilled is the APIError wrapped by NewOOMFuncError, surfaced to
+// clients as a 507 (matching what Docker/Podman expose via inspect's
+// OOMKilled flag).
+var ErrCallOOMKilled = models.NewAPIError(http.StatusInsufficientStorage, errors.New("container was killed due to out of memory"))
+
+// NewOOMFuncError returns a FuncError for a call whose container was
+// OOM-killed, distinguishable from a generic FuncError via IsOOMError.
+func NewOOMFuncError(err models.APIError) error { return oomFuncError{concFuncError{err}} }
+
+// IsOOMError checks if err is a FuncError raised by an OOM-killed
+// container.
+func IsOOMError(err error) bool { _, ok := err.(oomFuncError); return ok }
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+// ContainerFreezer pauses and resumes a running container's cgroup
+// freezer, as invoked by the runner driver (docker pause/unpause, or the
+// runc freezer directly). It is the hook Freeze and Thaw invoke to reclaim
+// CPU from a long-idle streaming call without tearing down the in-flight
+// request.
+type ContainerFreezer interface {
+	Pause(ctx context.Context, containerID string) error
+	Unpause(ctx context.Context, containerID string) error
+}
+
+var containerFrozenDurationMeasure = stats.Float64("container_frozen_duration_seconds", "time a container spent frozen between Freeze and Thaw", stats.UnitSeconds)
+
+func init() {
+	err := view.Register(&view.View{
+		Name:        "container_frozen_duration_seconds",
+		Measure:     containerFrozenDurationMeasure,
+		Description: "time a container spent frozen between Freeze and Thaw",
+		Aggregation: view.Distribution(0, .01, .05, .1, .5, 1, 5, 10, 30, 60),
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Freeze cooperatively pauses a busy container mid-request: it invokes the
+// configured ContainerFreezer (docker pause / runc freezer) and transitions
+// busy->paused directly, without passing back through idle, so the
+// in-flight request's slot isn't released. The real pause only ever runs
+// against a container that's actually ContainerStateBusy; calling Freeze
+// on anything else (wait/start/idle) still applies the state's own
+// transition rules but never invokes the freezer. If no ContainerFreezer
+// is configured, only the state transition happens.
+func (c *containerState) Freeze(ctx context.Context, call *call) error {
+	c.lock.Lock()
+	freezer := c.freezer
+	containerID := c.containerID
+	busy := c.state == ContainerStateBusy
+	c.lock.Unlock()
+
+	if freezer != nil && busy {
+		if err := freezer.Pause(ctx, containerID); err != nil {
+			return err
+		}
+	}
+
+	if !c.UpdateState(ctx, ContainerStatePaused, call) || !busy {
+		// already paused, some other disallowed transition, or a
+		// non-busy container moved forward to paused: nothing actually
+		// froze, so don't stamp frozenAt.
+		return nil
+	}
+
+	c.lock.Lock()
+	c.frozenAt = time.Now()
+	c.lock.Unlock()
+
+	return nil
+}
+
+// Thaw reverses Freeze: it invokes the configured ContainerFreezer to
+// resume the container and transitions paused->busy so the in-flight
+// request can continue.
+func (c *containerState) Thaw(ctx context.Context, call *call) error {
+	c.lock.Lock()
+	freezer := c.freezer
+	containerID := c.containerID
+	frozenAt := c.frozenAt
+	c.lock.Unlock()
+
+	if frozenAt.IsZero() {
+		// nothing to thaw: either never frozen, or already thawed by a
+		// previous call.
+		return nil
+	}
+
+	if freezer != nil {
+		if err := freezer.Unpause(ctx, containerID); err != nil {
+			return err
+		}
+	}
+
+	changed := c.UpdateState(ctx, ContainerStateBusy, call)
+
+	c.lock.Lock()
+	c.frozenAt = time.Time{}
+	c.lock.Unlock()
+
+	if changed {
+		stats.Record(ctx, containerFrozenDurationMeasure.M(time.Since(frozenAt).Seconds()))
+	}
+
+	return nil
+}
+
+// SetFreezer attaches the ContainerFreezer that Freeze/Thaw invoke. The
+// default, set by NewContainerState, is the Docker Engine API-backed
+// dockerFreezer; pass nil (or a fake) to have Freeze/Thaw only
+// transition state without pausing/unpausing anything, e.g. in tests.
+func (c *containerState) SetFreezer(freezer ContainerFreezer) {
+	c.lock.Lock()
+	c.freezer = freezer
+	c.lock.Unlock()
+}
@@ -0,0 +1,300 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+// HealthStatus is the coarse status a Health tracker reports, mirroring
+// Docker/Moby's container Health status; Health pairs it with a rolling
+// log of the probes that produced it.
+type HealthStatus int
+
+const (
+	HealthNone HealthStatus = iota // no HealthChecker configured
+	HealthStarting
+	HealthHealthy
+	HealthUnhealthy
+)
+
+func (h HealthStatus) String() string {
+	switch h {
+	case HealthStarting:
+		return "starting"
+	case HealthHealthy:
+		return "healthy"
+	case HealthUnhealthy:
+		return "unhealthy"
+	default:
+		return "none"
+	}
+}
+
+// maxHealthLogEntries bounds the rolling probe log kept per container,
+// same rationale as Docker's default health log cap.
+const maxHealthLogEntries = 5
+
+// ProbeResult is the outcome of a single health probe.
+type ProbeResult struct {
+	Healthy   bool
+	Output    string
+	ExitCode  int
+	Timestamp time.Time
+	Duration  time.Duration
+}
+
+// HealthChecker probes a hot container to decide whether it is still fit
+// to receive requests.
+type HealthChecker interface {
+	Probe(ctx context.Context, target string) ProbeResult
+}
+
+// Health tracks the rolling probe log and consecutive-failure count for a
+// single container, guarding its own state so it can be read concurrently
+// with the probe loop that writes it.
+type Health struct {
+	lock     sync.Mutex
+	status   HealthStatus
+	failures uint
+	log      []ProbeResult
+}
+
+// newHealth returns a Health tracker in HealthNone, the state of a
+// container with no configured HealthChecker.
+func newHealth() *Health {
+	return &Health{status: HealthNone}
+}
+
+// record appends res to the rolling log, updates status/failure count, and
+// returns true once res is the `retries`'th consecutive failure, meaning
+// the caller should force the container to ContainerStateDone.
+func (h *Health) record(res ProbeResult, retries uint) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.log = append(h.log, res)
+	if len(h.log) > maxHealthLogEntries {
+		h.log = h.log[len(h.log)-maxHealthLogEntries:]
+	}
+
+	if res.Healthy {
+		h.failures = 0
+		h.status = HealthHealthy
+		stats.Record(context.Background(), containerHealthyMeasure.M(1))
+		return false
+	}
+
+	h.failures++
+	h.status = HealthUnhealthy
+	stats.Record(context.Background(), containerUnhealthyMeasure.M(1))
+	return h.failures >= retries
+}
+
+// setStatus sets the coarse health status under h.lock, the same lock
+// record/Status use. Callers outside this file (e.g. RunHealthChecks)
+// must go through this rather than poking h.status directly -- it's a
+// different mutex than containerState's own, and a direct write races
+// with any concurrent GetHealth() call.
+func (h *Health) setStatus(status HealthStatus) {
+	h.lock.Lock()
+	h.status = status
+	h.lock.Unlock()
+}
+
+// Status returns the current health status and a copy of the rolling probe
+// log, most recent last.
+func (h *Health) Status() (HealthStatus, []ProbeResult) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	res := make([]ProbeResult, len(h.log))
+	copy(res, h.log)
+	return h.status, res
+}
+
+// HealthCheckConfig configures the probe run against a hot container. It
+// is read off a function's Config map (FN_HEALTHCHECK_*) so it can be set
+// per function without a models.Fn schema change.
+type HealthCheckConfig struct {
+	Type     string // "http", "tcp", or "exec"
+	Target   string // URL for http, host:port for tcp, command for exec
+	Interval time.Duration
+	Timeout  time.Duration
+	Retries  uint
+}
+
+const (
+	healthCheckTypeKey     = "FN_HEALTHCHECK_TYPE"
+	healthCheckTargetKey   = "FN_HEALTHCHECK_TARGET"
+	healthCheckIntervalKey = "FN_HEALTHCHECK_INTERVAL"
+	healthCheckTimeoutKey  = "FN_HEALTHCHECK_TIMEOUT"
+	healthCheckRetriesKey  = "FN_HEALTHCHECK_RETRIES"
+
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = 1 * time.Second
+	defaultHealthCheckRetries  = 3
+)
+
+// healthCheckConfigFromCall reads a HealthCheckConfig out of call's
+// function Config, returning ok=false if no health check is configured.
+func healthCheckConfigFromCall(cfg map[string]string) (HealthCheckConfig, bool) {
+	typ, ok := cfg[healthCheckTypeKey]
+	if !ok || typ == "" {
+		return HealthCheckConfig{}, false
+	}
+
+	hc := HealthCheckConfig{
+		Type:     typ,
+		Target:   cfg[healthCheckTargetKey],
+		Interval: defaultHealthCheckInterval,
+		Timeout:  defaultHealthCheckTimeout,
+		Retries:  defaultHealthCheckRetries,
+	}
+
+	if v, err := time.ParseDuration(cfg[healthCheckIntervalKey]); err == nil {
+		hc.Interval = v
+	}
+	if v, err := time.ParseDuration(cfg[healthCheckTimeoutKey]); err == nil {
+		hc.Timeout = v
+	}
+	if v, err := strconv.ParseUint(cfg[healthCheckRetriesKey], 10, 0); err == nil {
+		hc.Retries = uint(v)
+	}
+
+	return hc, true
+}
+
+// newHealthChecker constructs the HealthChecker named by cfg.Type. An
+// "exec" checker runs its probe inside containerID via executor, so it
+// needs both; executor may be nil for "http"/"tcp".
+func newHealthChecker(cfg HealthCheckConfig, containerID string, executor ContainerExecutor) (HealthChecker, error) {
+	switch cfg.Type {
+	case "http":
+		return &httpHealthChecker{timeout: cfg.Timeout}, nil
+	case "tcp":
+		return &tcpHealthChecker{timeout: cfg.Timeout}, nil
+	case "exec":
+		if executor == nil {
+			return nil, fmt.Errorf("agent: exec health check requires a ContainerExecutor")
+		}
+		return &execHealthChecker{executor: executor, containerID: containerID, timeout: cfg.Timeout}, nil
+	default:
+		return nil, fmt.Errorf("agent: unknown health check type %q", cfg.Type)
+	}
+}
+
+// httpHealthChecker probes by issuing a GET against target and treating
+// any 2xx response as healthy.
+type httpHealthChecker struct {
+	timeout time.Duration
+}
+
+func (h *httpHealthChecker) Probe(ctx context.Context, target string) ProbeResult {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return ProbeResult{Healthy: false, Output: err.Error(), Timestamp: start, Duration: time.Since(start)}
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return ProbeResult{Healthy: false, Output: err.Error(), Timestamp: start, Duration: time.Since(start)}
+	}
+	defer resp.Body.Close()
+
+	healthy := resp.StatusCode >= 200 && resp.StatusCode < 300
+	return ProbeResult{
+		Healthy:   healthy,
+		Output:    resp.Status,
+		ExitCode:  resp.StatusCode,
+		Timestamp: start,
+		Duration:  time.Since(start),
+	}
+}
+
+// tcpHealthChecker probes by dialing target; a successful connect is
+// healthy.
+type tcpHealthChecker struct {
+	timeout time.Duration
+}
+
+func (t *tcpHealthChecker) Probe(ctx context.Context, target string) ProbeResult {
+	start := time.Now()
+
+	conn, err := net.DialTimeout("tcp", target, t.timeout)
+	if err != nil {
+		return ProbeResult{Healthy: false, Output: err.Error(), Timestamp: start, Duration: time.Since(start)}
+	}
+	conn.Close()
+
+	return ProbeResult{Healthy: true, Timestamp: start, Duration: time.Since(start)}
+}
+
+// ContainerExecutor runs a command inside a specific container's own
+// namespace, as the runner driver implements it (docker exec / runc
+// exec). execHealthChecker uses it so "exec" health probes run sandboxed
+// inside the function's container, the same as Docker's HEALTHCHECK CMD,
+// rather than on the agent host.
+type ContainerExecutor interface {
+	Exec(ctx context.Context, containerID string, cmd string) (output string, exitCode int, err error)
+}
+
+// execHealthChecker probes by running a command inside containerID via
+// executor; an exit code of 0 is healthy, matching Docker's HEALTHCHECK
+// CMD semantics.
+type execHealthChecker struct {
+	executor    ContainerExecutor
+	containerID string
+	timeout     time.Duration
+}
+
+func (e *execHealthChecker) Probe(ctx context.Context, target string) ProbeResult {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	output, exitCode, err := e.executor.Exec(ctx, e.containerID, target)
+	return ProbeResult{
+		Healthy:   err == nil && exitCode == 0,
+		Output:    output,
+		ExitCode:  exitCode,
+		Timestamp: start,
+		Duration:  time.Since(start),
+	}
+}
+
+var (
+	containerHealthyMeasure   = stats.Int64("container_healthy_total", "count of healthy probes", stats.UnitDimensionless)
+	containerUnhealthyMeasure = stats.Int64("container_unhealthy_total", "count of unhealthy probes", stats.UnitDimensionless)
+)
+
+func init() {
+	err := view.Register(
+		&view.View{
+			Name:        "container_healthy_total",
+			Measure:     containerHealthyMeasure,
+			Description: "count of healthy probes",
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "container_unhealthy_total",
+			Measure:     containerUnhealthyMeasure,
+			Description: "count of unhealthy probes",
+			Aggregation: view.Count(),
+		},
+	)
+	if err != nil {
+		panic(err)
+	}
+}
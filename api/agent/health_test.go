@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHealthConcurrentRecordAndStatus exercises record/setStatus/Status
+// concurrently so `go test -race` catches any regression of the Health.status
+// data race between RunHealthChecks (record/setStatus) and GetHealth
+// (Status) that h.lock exists to prevent.
+func TestHealthConcurrentRecordAndStatus(t *testing.T) {
+	h := newHealth()
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			h.record(ProbeResult{Healthy: i%2 == 0, Timestamp: time.Now()}, 3)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			h.setStatus(HealthStarting)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			h.Status()
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestHealthRecordCapsLog(t *testing.T) {
+	h := newHealth()
+	for i := 0; i < maxHealthLogEntries+2; i++ {
+		h.record(ProbeResult{Healthy: true, Timestamp: time.Now()}, 3)
+	}
+
+	status, log := h.Status()
+	if status != HealthHealthy {
+		t.Fatalf("expected HealthHealthy, got %v", status)
+	}
+	if len(log) != maxHealthLogEntries {
+		t.Fatalf("expected log capped at %d entries, got %d", maxHealthLogEntries, len(log))
+	}
+}
+
+func TestHealthRecordReportsRetriesExhausted(t *testing.T) {
+	h := newHealth()
+
+	var evicted bool
+	for i := 0; i < 3; i++ {
+		evicted = h.record(ProbeResult{Healthy: false, Timestamp: time.Now()}, 3)
+	}
+	if !evicted {
+		t.Fatalf("expected record to report eviction after 3 consecutive failures")
+	}
+
+	status, _ := h.Status()
+	if status != HealthUnhealthy {
+		t.Fatalf("expected HealthUnhealthy, got %v", status)
+	}
+}
@@ -0,0 +1,314 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StateEvent is a single container lifecycle transition, as recorded by a
+// StateJournal. It carries enough context to reconstruct hot-container
+// occupancy and per-function churn without querying OpenCensus.
+type StateEvent struct {
+	AppID          string        `json:"app_id"`
+	FnID           string        `json:"fn_id"`
+	Image          string        `json:"image"`
+	ContainerID    string        `json:"container_id"`
+	OldState       string        `json:"old_state"`
+	NewState       string        `json:"new_state"`
+	Timestamp      time.Time     `json:"timestamp"`
+	DurationInPrev time.Duration `json:"duration_in_prev"`
+	ExitCode       int           `json:"exit_code,omitempty"`
+	ErrorMsg       string        `json:"error_msg,omitempty"`
+}
+
+// StateJournal persists container lifecycle transitions so they survive
+// agent restarts, for post-mortem debugging of cold-start regressions and
+// container churn, and for downstream tooling (billing, analytics) that
+// wants to tail events rather than scrape metrics.
+type StateJournal interface {
+	// Record appends evt to the journal. Implementations must not block
+	// UpdateState callers on slow I/O for longer than necessary.
+	Record(ctx context.Context, evt StateEvent)
+
+	// Replay streams every recorded event with Timestamp >= since, in
+	// order, closing the returned channel once exhausted.
+	Replay(since time.Time) <-chan StateEvent
+
+	// Close flushes and releases any underlying resources.
+	Close() error
+}
+
+// noopStateJournal is the default StateJournal, used when no on-disk
+// journal is configured. It discards every event.
+type noopStateJournal struct{}
+
+// NewNoopStateJournal returns a StateJournal that discards all events.
+func NewNoopStateJournal() StateJournal { return noopStateJournal{} }
+
+func (noopStateJournal) Record(ctx context.Context, evt StateEvent) {}
+func (noopStateJournal) Replay(since time.Time) <-chan StateEvent {
+	ch := make(chan StateEvent)
+	close(ch)
+	return ch
+}
+func (noopStateJournal) Close() error { return nil }
+
+// stateJournalPathEnv names the on-disk JSONL journal an agent should
+// record container lifecycle transitions to. Unset (the default) means
+// no journal: OpenDefaultStateJournal returns the no-op implementation,
+// matching NewContainerState's own default.
+const stateJournalPathEnv = "FN_STATE_JOURNAL_PATH"
+
+// OpenDefaultStateJournal opens the StateJournal configured by
+// FN_STATE_JOURNAL_PATH for the agent's lifetime, or the no-op journal if
+// that var is unset. Callers wire the result into every containerState
+// they create via ContainerState.SetJournal.
+func OpenDefaultStateJournal() (StateJournal, error) {
+	path := os.Getenv(stateJournalPathEnv)
+	if path == "" {
+		return NewNoopStateJournal(), nil
+	}
+	return NewJSONLStateJournal(path)
+}
+
+// defaultJournalRotateBytes is the size at which the active journal file is
+// rotated to a timestamped sibling.
+const defaultJournalRotateBytes = 64 * 1024 * 1024
+
+// defaultJournalSyncInterval is how often the journal fsyncs the active
+// file when events are flowing.
+const defaultJournalSyncInterval = 1 * time.Second
+
+// jsonlStateJournal is a StateJournal backed by an append-only JSONL file.
+// It fsyncs periodically rather than per-event, and rotates the file by
+// size so it doesn't grow unbounded on long-lived agents.
+type jsonlStateJournal struct {
+	path         string
+	rotateBytes  int64
+	syncInterval time.Duration
+
+	lock    sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	written int64
+	dirty   bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewJSONLStateJournal opens (or creates) path as an append-only JSONL
+// journal and starts a background fsync loop. Call Close to stop the loop
+// and flush outstanding writes.
+func NewJSONLStateJournal(path string) (StateJournal, error) {
+	j := &jsonlStateJournal{
+		path:         path,
+		rotateBytes:  defaultJournalRotateBytes,
+		syncInterval: defaultJournalSyncInterval,
+		done:         make(chan struct{}),
+	}
+
+	if err := j.openActive(); err != nil {
+		return nil, err
+	}
+
+	j.wg.Add(1)
+	go j.syncLoop()
+
+	return j, nil
+}
+
+func (j *jsonlStateJournal) openActive() error {
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("agent: could not open state journal %q: %v", j.path, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("agent: could not stat state journal %q: %v", j.path, err)
+	}
+
+	j.file = f
+	j.writer = bufio.NewWriter(f)
+	j.written = info.Size()
+	return nil
+}
+
+func (j *jsonlStateJournal) Record(ctx context.Context, evt StateEvent) {
+	buf, err := json.Marshal(evt)
+	if err != nil {
+		logrus.WithError(err).Error("agent: failed to marshal state journal event")
+		return
+	}
+	buf = append(buf, '\n')
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.file == nil {
+		return
+	}
+
+	if j.written+int64(len(buf)) > j.rotateBytes {
+		if err := j.rotateLocked(); err != nil {
+			logrus.WithError(err).Error("agent: failed to rotate state journal")
+		}
+	}
+
+	n, err := j.writer.Write(buf)
+	if err != nil {
+		logrus.WithError(err).Error("agent: failed to write state journal event")
+		return
+	}
+
+	j.written += int64(n)
+	j.dirty = true
+}
+
+// rotateLocked closes the active file, renames it alongside a timestamp,
+// and opens a fresh active file in its place. Callers must hold j.lock.
+func (j *jsonlStateJournal) rotateLocked() error {
+	if err := j.flushLocked(); err != nil {
+		return err
+	}
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := fmt.Sprintf("%s.%d", j.path, time.Now().UnixNano())
+	if err := os.Rename(j.path, rotated); err != nil {
+		return err
+	}
+
+	return j.openActive()
+}
+
+func (j *jsonlStateJournal) flushLocked() error {
+	if j.writer != nil {
+		if err := j.writer.Flush(); err != nil {
+			return err
+		}
+	}
+	if j.dirty && j.file != nil {
+		if err := j.file.Sync(); err != nil {
+			return err
+		}
+		j.dirty = false
+	}
+	return nil
+}
+
+func (j *jsonlStateJournal) syncLoop() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(j.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.lock.Lock()
+			if err := j.flushLocked(); err != nil {
+				logrus.WithError(err).Error("agent: failed to sync state journal")
+			}
+			j.lock.Unlock()
+		case <-j.done:
+			return
+		}
+	}
+}
+
+func (j *jsonlStateJournal) Close() error {
+	var err error
+	j.closeOnce.Do(func() {
+		close(j.done)
+		j.wg.Wait()
+
+		j.lock.Lock()
+		defer j.lock.Unlock()
+
+		if ferr := j.flushLocked(); ferr != nil {
+			err = ferr
+		}
+		if j.file != nil {
+			if cerr := j.file.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+			j.file = nil
+		}
+	})
+	return err
+}
+
+// Replay reads every rotated file followed by the active file (in that
+// order) and streams every event with Timestamp >= since. The returned
+// channel is closed once every file has been read; a file that can't be
+// opened and a line that doesn't parse are logged and skipped, and a read
+// error ends that file's replay without closing the channel early.
+func (j *jsonlStateJournal) Replay(since time.Time) <-chan StateEvent {
+	out := make(chan StateEvent)
+
+	j.lock.Lock()
+	err := j.flushLocked()
+	j.lock.Unlock()
+	if err != nil {
+		logrus.WithError(err).Error("agent: failed to flush state journal before replay")
+	}
+
+	go func() {
+		defer close(out)
+
+		for _, path := range j.replayPaths() {
+			if !replayFile(path, since, out) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (j *jsonlStateJournal) replayPaths() []string {
+	matches, err := filepath.Glob(j.path + ".*")
+	if err != nil {
+		logrus.WithError(err).Error("agent: failed to list state journal rotations")
+	}
+	return append(matches, j.path)
+}
+
+func replayFile(path string, since time.Time, out chan<- StateEvent) bool {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("path", path).Error("agent: failed to open state journal for replay")
+		return true
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var evt StateEvent
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			logrus.WithError(err).WithField("path", path).Error("agent: skipping corrupt state journal entry")
+			continue
+		}
+		if evt.Timestamp.Before(since) {
+			continue
+		}
+		out <- evt
+	}
+	return true
+}
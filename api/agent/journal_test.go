@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestJSONLStateJournalRoundTrip writes events through a jsonlStateJournal
+// with a byte-sized rotation threshold (forcing a rotation per write) and
+// checks that Replay returns every event, in order, across both the
+// rotated files and the still-buffered active file -- and that a
+// since-cutoff only returns events at or after it.
+func TestJSONLStateJournalRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+
+	j := &jsonlStateJournal{
+		path:         path,
+		rotateBytes:  1,
+		syncInterval: time.Hour,
+		done:         make(chan struct{}),
+	}
+	if err := j.openActive(); err != nil {
+		t.Fatalf("openActive: %v", err)
+	}
+	defer j.Close()
+
+	base := time.Now().Truncate(time.Second)
+	want := []StateEvent{
+		{ContainerID: "c1", OldState: "wait", NewState: "start", Timestamp: base},
+		{ContainerID: "c1", OldState: "start", NewState: "idle", Timestamp: base.Add(time.Second)},
+		{ContainerID: "c1", OldState: "idle", NewState: "busy", Timestamp: base.Add(2 * time.Second)},
+	}
+	for _, evt := range want {
+		j.Record(context.Background(), evt)
+	}
+
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatalf("glob rotations: %v", err)
+	}
+	if len(matches) == 0 {
+		t.Fatalf("expected rotateBytes=1 to force at least one rotation, found none")
+	}
+
+	// Replay must flush the still-buffered active file before reading, so
+	// even events the background sync loop hasn't fsynced yet show up.
+	var got []StateEvent
+	for evt := range j.Replay(time.Time{}) {
+		got = append(got, evt)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if !got[i].Timestamp.Equal(want[i].Timestamp) || got[i].NewState != want[i].NewState {
+			t.Fatalf("event %d mismatch: want %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	var filtered []StateEvent
+	for evt := range j.Replay(base.Add(2 * time.Second)) {
+		filtered = append(filtered, evt)
+	}
+	if len(filtered) != 1 || filtered[0].NewState != "busy" {
+		t.Fatalf("expected only the event at the cutoff, got %+v", filtered)
+	}
+}
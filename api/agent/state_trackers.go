@@ -2,10 +2,13 @@ package agent
 
 import (
 	"context"
+	"fmt"
 	"go.opencensus.io/tag"
 	"sync"
 	"time"
 
+	"github.com/fnproject/fn/api/models"
+	"github.com/sirupsen/logrus"
 	"go.opencensus.io/stats"
 )
 
@@ -13,31 +16,137 @@ type RequestStateType int
 type ContainerStateType int
 
 type containerState struct {
-	lock  sync.Mutex
-	state ContainerStateType
-	start time.Time
+	lock        sync.Mutex
+	state       ContainerStateType
+	start       time.Time
+	waiters     map[ContainerStateType][]chan ContainerStateType
+	journal     StateJournal
+	containerID string
+	health      *Health
+	executor    ContainerExecutor
+	freezer     ContainerFreezer
+	frozenAt    time.Time
+	exitCode    int
+	oomKilled   bool
+	termErr     error
 }
 
 type requestState struct {
-	lock  sync.Mutex
-	state RequestStateType
-	start time.Time
+	lock    sync.Mutex
+	state   RequestStateType
+	start   time.Time
+	waiters map[RequestStateType][]chan RequestStateType
 }
 
 type ContainerState interface {
-	UpdateState(ctx context.Context, newState ContainerStateType, call *call)
+	// UpdateState applies the transition to newState if it's one of the
+	// allowed moves (see the implementation for the full list) and
+	// reports whether it actually happened; a disallowed transition is a
+	// silent no-op that reports false.
+	UpdateState(ctx context.Context, newState ContainerStateType, call *call) bool
 	GetState() string
+
+	// Wait blocks until the container reaches target, reaches a terminal
+	// state (ContainerStateDone), or ctx is cancelled, whichever happens
+	// first. It returns the state that was actually reached.
+	Wait(ctx context.Context, target ContainerStateType) (ContainerStateType, error)
+
+	// SetJournal attaches a StateJournal that every subsequent transition
+	// is recorded to. The default, set by NewContainerState, is a no-op.
+	SetJournal(journal StateJournal)
+
+	// SetContainerID records the driver-assigned container ID so it can
+	// be attached to journal entries once the container is created.
+	SetContainerID(id string)
+
+	// GetHealth returns the current Health status and a copy of its
+	// rolling probe log, most recent last. A container with no configured
+	// HealthChecker reports HealthNone and an empty log.
+	GetHealth() (HealthStatus, []ProbeResult)
+
+	// RunHealthChecks reads a HealthCheckConfig off call's function config
+	// and, if one is configured, probes the container on cfg.Interval for
+	// as long as it stays in ContainerStateIdle/ContainerStatePaused.
+	// After cfg.Retries consecutive failures it forces the container to
+	// ContainerStateDone so the slot manager evicts it. It returns once
+	// the container reaches ContainerStateDone, ctx is cancelled, or no
+	// health check is configured for call.
+	RunHealthChecks(ctx context.Context, call *call)
+
+	// SetExecutor attaches the ContainerExecutor that an "exec"-type
+	// health check runs its probe command through, sandboxed inside the
+	// container rather than on the agent host.
+	SetExecutor(executor ContainerExecutor)
+
+	// Freeze cooperatively pauses a busy container directly to paused,
+	// without passing back through idle, for mid-request CPU reclaim.
+	Freeze(ctx context.Context, call *call) error
+
+	// Thaw reverses Freeze, transitioning paused back to busy.
+	Thaw(ctx context.Context, call *call) error
+
+	// SetFreezer attaches the ContainerFreezer that Freeze/Thaw invoke to
+	// actually pause/unpause the container.
+	SetFreezer(freezer ContainerFreezer)
+
+	// Terminate records the container's exit code, OOMKilled flag, and
+	// any error observed tearing it down, then transitions it to
+	// ContainerStateDone. Call it once, when the container process exits.
+	Terminate(ctx context.Context, call *call, exitCode int, oomKilled bool, err error)
+
+	// GetTerminationInfo returns the values last recorded by Terminate.
+	// Before Terminate is called it returns the zero values.
+	GetTerminationInfo() (exitCode int, oomKilled bool, err error)
+
+	// TerminationError turns the info last recorded by Terminate into a
+	// FuncError: NewOOMFuncError if the container was OOM-killed, else
+	// fallback wrapped via NewFuncError if a teardown error was recorded,
+	// else nil. Callers use this instead of reading GetTerminationInfo
+	// and picking the right constructor themselves.
+	TerminationError(fallback models.APIError) error
 }
 type RequestState interface {
 	UpdateState(ctx context.Context, newState RequestStateType, slots *slotQueue)
+
+	// WaitRequest blocks until the request reaches target, reaches a
+	// terminal state (RequestStateDone), or ctx is cancelled, whichever
+	// happens first. It returns the state that was actually reached.
+	WaitRequest(ctx context.Context, target RequestStateType) (RequestStateType, error)
 }
 
 func NewRequestState() RequestState {
 	return &requestState{}
 }
 
+// NewContainerState returns a ContainerState wired to the default
+// docker-backed ContainerFreezer and ContainerExecutor. SetFreezer and
+// SetExecutor can still override either after construction, e.g. for
+// tests.
 func NewContainerState() ContainerState {
-	return &containerState{}
+	return &containerState{
+		journal:  NewNoopStateJournal(),
+		health:   newHealth(),
+		freezer:  NewDockerFreezer(),
+		executor: NewDockerExecutor(),
+	}
+}
+
+func (c *containerState) SetJournal(journal StateJournal) {
+	c.lock.Lock()
+	c.journal = journal
+	c.lock.Unlock()
+}
+
+func (c *containerState) SetContainerID(id string) {
+	c.lock.Lock()
+	c.containerID = id
+	c.lock.Unlock()
+}
+
+func (c *containerState) SetExecutor(executor ContainerExecutor) {
+	c.lock.Lock()
+	c.executor = executor
+	c.lock.Unlock()
 }
 
 const (
@@ -92,6 +201,7 @@ func (c *requestState) UpdateState(ctx context.Context, newState RequestStateTyp
 
 	var now time.Time
 	var oldState RequestStateType
+	var notify []chan RequestStateType
 
 	c.lock.Lock()
 
@@ -102,6 +212,7 @@ func (c *requestState) UpdateState(ctx context.Context, newState RequestStateTyp
 		oldState = c.state
 		c.state = newState
 		c.start = now
+		notify = c.popWaiters(newState)
 	}
 
 	c.lock.Unlock()
@@ -110,6 +221,10 @@ func (c *requestState) UpdateState(ctx context.Context, newState RequestStateTyp
 		return
 	}
 
+	for _, ch := range notify {
+		ch <- newState
+	}
+
 	// reflect this change to slot mgr if defined (AKA hot)
 	if slots != nil {
 		slots.enterRequestState(newState)
@@ -117,6 +232,77 @@ func (c *requestState) UpdateState(ctx context.Context, newState RequestStateTyp
 	}
 }
 
+// popWaiters removes and returns the channels waiting on newState (or, if
+// newState is terminal, every outstanding waiter). Must be called with
+// c.lock held.
+func (c *requestState) popWaiters(newState RequestStateType) []chan RequestStateType {
+	if c.waiters == nil {
+		return nil
+	}
+
+	var out []chan RequestStateType
+	if newState == RequestStateDone {
+		for target, chans := range c.waiters {
+			out = append(out, chans...)
+			delete(c.waiters, target)
+		}
+		return out
+	}
+
+	out = c.waiters[newState]
+	delete(c.waiters, newState)
+	return out
+}
+
+// WaitRequest blocks until the request reaches target, reaches
+// RequestStateDone, or ctx is cancelled.
+func (c *requestState) WaitRequest(ctx context.Context, target RequestStateType) (RequestStateType, error) {
+	c.lock.Lock()
+
+	if c.state == target || c.state == RequestStateDone {
+		state := c.state
+		c.lock.Unlock()
+		return state, nil
+	}
+
+	ch := make(chan RequestStateType, 1)
+	if c.waiters == nil {
+		c.waiters = make(map[RequestStateType][]chan RequestStateType)
+	}
+	c.waiters[target] = append(c.waiters[target], ch)
+
+	c.lock.Unlock()
+
+	select {
+	case state := <-ch:
+		return state, nil
+	case <-ctx.Done():
+		c.lock.Lock()
+		c.removeWaiter(target, ch)
+		c.lock.Unlock()
+		return RequestStateNone, ctx.Err()
+	}
+}
+
+// removeWaiter deletes ch from the waiters registered for target, if it's
+// still there. Must be called with c.lock held. It's the cleanup half of
+// WaitRequest's ctx.Done() path: without it, a cancelled/timed-out wait
+// leaves its channel registered until the state actually reaches target,
+// leaking one entry (and one unread send from UpdateState) per call for a
+// container that stays put.
+func (c *requestState) removeWaiter(target RequestStateType, ch chan RequestStateType) {
+	chans := c.waiters[target]
+	for i, cand := range chans {
+		if cand == ch {
+			c.waiters[target] = append(chans[:i], chans[i+1:]...)
+			break
+		}
+	}
+	if len(c.waiters[target]) == 0 {
+		delete(c.waiters, target)
+	}
+}
+
 func isIdleState(state ContainerStateType) bool {
 	return state == ContainerStateIdle || state == ContainerStatePaused
 }
@@ -131,6 +317,162 @@ func (c *containerState) GetState() string {
 	return containerStateKeys[res]
 }
 
+func (c *containerState) GetHealth() (HealthStatus, []ProbeResult) {
+	return c.health.Status()
+}
+
+// Terminate records the container's exit code, OOMKilled flag, and any
+// teardown error, then transitions it to ContainerStateDone.
+func (c *containerState) Terminate(ctx context.Context, call *call, exitCode int, oomKilled bool, err error) {
+	c.lock.Lock()
+	c.exitCode = exitCode
+	c.oomKilled = oomKilled
+	c.termErr = err
+	c.lock.Unlock()
+
+	c.UpdateState(ctx, ContainerStateDone, call)
+}
+
+// GetTerminationInfo returns the values last recorded by Terminate.
+func (c *containerState) GetTerminationInfo() (exitCode int, oomKilled bool, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.exitCode, c.oomKilled, c.termErr
+}
+
+// TerminationError turns the info last recorded by Terminate into a
+// FuncError, distinguishing an OOM-killed container from any other
+// teardown error so clients and the billing layer can tell them apart.
+func (c *containerState) TerminationError(fallback models.APIError) error {
+	_, oomKilled, err := c.GetTerminationInfo()
+
+	switch {
+	case oomKilled:
+		return NewOOMFuncError(ErrCallOOMKilled)
+	case err != nil:
+		return NewFuncError(fallback)
+	default:
+		return nil
+	}
+}
+
+// RunHealthChecks reads a HealthCheckConfig off call.Config and, if one is
+// configured, probes the container on cfg.Interval for as long as it
+// stays idle/paused, forcing ContainerStateDone after cfg.Retries
+// consecutive failures.
+func (c *containerState) RunHealthChecks(ctx context.Context, call *call) {
+	cfg, ok := healthCheckConfigFromCall(call.Config)
+	if !ok {
+		return
+	}
+
+	c.lock.Lock()
+	containerID := c.containerID
+	executor := c.executor
+	c.lock.Unlock()
+
+	checker, err := newHealthChecker(cfg, containerID, executor)
+	if err != nil {
+		logrus.WithError(err).WithField("container_id", containerID).Error("agent: could not start health checks")
+		return
+	}
+
+	c.health.setStatus(HealthStarting)
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		switch c.GetState() {
+		case containerStateKeys[ContainerStateDone]:
+			return
+		case containerStateKeys[ContainerStateIdle], containerStateKeys[ContainerStatePaused]:
+			res := checker.Probe(ctx, cfg.Target)
+			if c.health.record(res, cfg.Retries) {
+				c.Terminate(ctx, call, 0, false, fmt.Errorf("evicted after %d consecutive health check failures", cfg.Retries))
+				return
+			}
+		default:
+			// busy, waiting, or starting: skip this tick
+		}
+	}
+}
+
+// popWaiters removes and returns the channels waiting on newState (or, if
+// newState is terminal, every outstanding waiter). Must be called with
+// c.lock held.
+func (c *containerState) popWaiters(newState ContainerStateType) []chan ContainerStateType {
+	if c.waiters == nil {
+		return nil
+	}
+
+	var out []chan ContainerStateType
+	if newState == ContainerStateDone {
+		for target, chans := range c.waiters {
+			out = append(out, chans...)
+			delete(c.waiters, target)
+		}
+		return out
+	}
+
+	out = c.waiters[newState]
+	delete(c.waiters, newState)
+	return out
+}
+
+// Wait blocks until the container reaches target, reaches
+// ContainerStateDone, or ctx is cancelled.
+func (c *containerState) Wait(ctx context.Context, target ContainerStateType) (ContainerStateType, error) {
+	c.lock.Lock()
+
+	if c.state == target || c.state == ContainerStateDone {
+		state := c.state
+		c.lock.Unlock()
+		return state, nil
+	}
+
+	ch := make(chan ContainerStateType, 1)
+	if c.waiters == nil {
+		c.waiters = make(map[ContainerStateType][]chan ContainerStateType)
+	}
+	c.waiters[target] = append(c.waiters[target], ch)
+
+	c.lock.Unlock()
+
+	select {
+	case state := <-ch:
+		return state, nil
+	case <-ctx.Done():
+		c.lock.Lock()
+		c.removeWaiter(target, ch)
+		c.lock.Unlock()
+		return ContainerStateNone, ctx.Err()
+	}
+}
+
+// removeWaiter deletes ch from the waiters registered for target, if it's
+// still there. Must be called with c.lock held. See requestState's
+// removeWaiter for why this matters: without it, a cancelled/timed-out
+// Wait leaks its channel for as long as the container sits in one state.
+func (c *containerState) removeWaiter(target ContainerStateType, ch chan ContainerStateType) {
+	chans := c.waiters[target]
+	for i, cand := range chans {
+		if cand == ch {
+			c.waiters[target] = append(chans[:i], chans[i+1:]...)
+			break
+		}
+	}
+	if len(c.waiters[target]) == 0 {
+		delete(c.waiters, target)
+	}
+}
+
 //This lets the metrics know if a hot container is currently being run for a given app/fn/Image
 func setHot(ctx context.Context, appId string, fnId string, imageName string, state ContainerStateType) {
 	if state != ContainerStateStart && state != ContainerStateDone {
@@ -154,12 +496,18 @@ func setHot(ctx context.Context, appId string, fnId string, imageName string, st
 	stats.Record(ctx, hotFunctionMeasure.M(isHotState))
 }
 
-func (c *containerState) UpdateState(ctx context.Context, newState ContainerStateType, call *call) {
+func (c *containerState) UpdateState(ctx context.Context, newState ContainerStateType, call *call) bool {
 	var slots = call.slots
 
 	var now time.Time
 	var oldState ContainerStateType
 	var before time.Time
+	var notify []chan ContainerStateType
+	var journal StateJournal
+	var containerID string
+	var exitCode int
+	var oomKilled bool
+	var termErr error
 
 	c.lock.Lock()
 
@@ -176,12 +524,44 @@ func (c *containerState) UpdateState(ctx context.Context, newState ContainerStat
 		before = c.start
 		c.state = newState
 		c.start = now
+		notify = c.popWaiters(newState)
+		journal = c.journal
+		containerID = c.containerID
+		exitCode = c.exitCode
+		oomKilled = c.oomKilled
+		termErr = c.termErr
 	}
 
 	c.lock.Unlock()
 
 	if now.IsZero() {
-		return
+		return false
+	}
+
+	for _, ch := range notify {
+		ch <- newState
+	}
+
+	if journal != nil {
+		evt := StateEvent{
+			AppID:          call.AppID,
+			FnID:           call.FnID,
+			Image:          call.Image,
+			ContainerID:    containerID,
+			OldState:       containerStateKeys[oldState],
+			NewState:       containerStateKeys[newState],
+			Timestamp:      now,
+			DurationInPrev: now.Sub(before),
+		}
+		if newState == ContainerStateDone {
+			evt.ExitCode = exitCode
+			if oomKilled {
+				evt.ErrorMsg = "oom-killed"
+			} else if termErr != nil {
+				evt.ErrorMsg = termErr.Error()
+			}
+		}
+		journal.Record(ctx, evt)
 	}
 
 	//call.AppID, call.FnID, call.Image
@@ -209,4 +589,6 @@ func (c *containerState) UpdateState(ctx context.Context, newState ContainerStat
 	if gaugeKey != "" {
 		stats.Record(ctx, containerGaugeMeasures[newState].M(1))
 	}
+
+	return true
 }
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiterCount polls until n waiters are registered for target, or
+// fails the test after a timeout. Wait/WaitRequest register their channel
+// asynchronously from the goroutine's point of view, so tests that need to
+// observe the registration (to then cancel or notify it) have to poll.
+func waitForWaiterCount(t *testing.T, get func() int, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if get() == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d registered waiter(s), have %d", n, get())
+}
+
+func TestContainerStateWaitCancellationRemovesWaiter(t *testing.T) {
+	c := &containerState{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Wait(ctx, ContainerStateIdle)
+		done <- err
+	}()
+
+	waitForWaiterCount(t, func() int {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return len(c.waiters[ContainerStateIdle])
+	}, 1)
+
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	c.lock.Lock()
+	leaked := len(c.waiters[ContainerStateIdle])
+	c.lock.Unlock()
+	if leaked != 0 {
+		t.Fatalf("expected cancelled Wait to deregister its channel, found %d left behind", leaked)
+	}
+}
+
+// TestContainerStateWaitFanOut drives the waiter map/notify path directly
+// (the same popWaiters + send sequence UpdateState runs) since UpdateState
+// itself needs a *call this package doesn't define in isolation. It
+// exercises the part of the chunk0-1 contract that matters here: every
+// concurrent Wait(target) call gets woken exactly once when target is
+// reached.
+func TestContainerStateWaitFanOut(t *testing.T) {
+	c := &containerState{}
+	const waiters = 5
+
+	results := make(chan ContainerStateType, waiters)
+	var wg sync.WaitGroup
+	wg.Add(waiters)
+	for i := 0; i < waiters; i++ {
+		go func() {
+			defer wg.Done()
+			state, err := c.Wait(context.Background(), ContainerStatePaused)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			results <- state
+		}()
+	}
+
+	waitForWaiterCount(t, func() int {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return len(c.waiters[ContainerStatePaused])
+	}, waiters)
+
+	c.lock.Lock()
+	c.state = ContainerStatePaused
+	notify := c.popWaiters(ContainerStatePaused)
+	c.lock.Unlock()
+	for _, ch := range notify {
+		ch <- ContainerStatePaused
+	}
+
+	wg.Wait()
+	close(results)
+
+	count := 0
+	for state := range results {
+		if state != ContainerStatePaused {
+			t.Fatalf("expected ContainerStatePaused, got %v", state)
+		}
+		count++
+	}
+	if count != waiters {
+		t.Fatalf("expected all %d waiters notified, got %d", waiters, count)
+	}
+}
+
+func TestRequestStateWaitRequestCancellationRemovesWaiter(t *testing.T) {
+	c := &requestState{}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.WaitRequest(ctx, RequestStateExec)
+		done <- err
+	}()
+
+	waitForWaiterCount(t, func() int {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return len(c.waiters[RequestStateExec])
+	}, 1)
+
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	c.lock.Lock()
+	leaked := len(c.waiters[RequestStateExec])
+	c.lock.Unlock()
+	if leaked != 0 {
+		t.Fatalf("expected cancelled WaitRequest to deregister its channel, found %d left behind", leaked)
+	}
+}